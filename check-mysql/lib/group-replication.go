@@ -1,20 +1,30 @@
 package checkmysql
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mackerelio/checkers"
-	"github.com/ziutek/mymysql/mysql"
 )
 
 type groupReplicationOpts struct {
 	mysqlSetting
-	LocalHostname string `long:"local-hostname" description:"Local hostname as a group member. See performance_schema.replication_group_members."`
-	LocalPort     string `long:"local-port" default:"3306" description:"Local port number as a group member. See performance_schema.replication_group_members."`
-	GroupMember   bool   `short:"g" long:"group-members" description:"Detect anomalies of other group members"`
+	LocalHostname   string `long:"local-hostname" description:"Local hostname as a group member. See performance_schema.replication_group_members."`
+	LocalPort       string `long:"local-port" default:"3306" description:"Local port number as a group member. See performance_schema.replication_group_members."`
+	GroupMember     bool   `long:"group-members" short:"g" description:"Detect anomalies of other group members"`
+	Output          string `long:"output" default:"text" description:"Output format. One of: text, json."`
+	WarnQueue       int64  `long:"warn-queue" description:"Warning threshold for the local member's applier/certification queue depth (COUNT_TRANSACTIONS_IN_QUEUE + COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE)"`
+	CritQueue       int64  `long:"crit-queue" description:"Critical threshold for the local member's applier/certification queue depth"`
+	WarnLagTxns     int64  `long:"warn-lag-txns" description:"Warning threshold for the gap between COUNT_TRANSACTIONS_CHECKED and COUNT_TRANSACTIONS_REMOTE_APPLIED"`
+	CritLagTxns     int64  `long:"crit-lag-txns" description:"Critical threshold for the gap between COUNT_TRANSACTIONS_CHECKED and COUNT_TRANSACTIONS_REMOTE_APPLIED"`
+	ExpectedMembers int64  `long:"expected-members" description:"Expected number of group members. CRITICAL when the ONLINE count drops below quorum (floor(N/2)+1), WARNING when members are missing but quorum still holds."`
+	RequirePrimary  bool   `long:"require-primary" description:"CRITICAL when running in single-primary mode and no PRIMARY member can be identified"`
+	ExpectRole      string `long:"expect-role" default:"any" description:"Expected MEMBER_ROLE of the local member (8.0+ only): primary, secondary, or any. WARNING when the role doesn't match, e.g. a former PRIMARY became SECONDARY after failover."`
+	CheckReadOnly   bool   `long:"check-read-only" description:"WARNING when a SECONDARY member does not have super_read_only=ON (8.0+ only)"`
 }
 
 type groupMember struct {
@@ -22,6 +32,20 @@ type groupMember struct {
 	State string
 }
 
+type memberStatus struct {
+	Host  string `json:"host"`
+	Port  string `json:"port"`
+	Role  string `json:"role"`
+	State string `json:"state"`
+}
+
+type clusterStatus struct {
+	Color            string         `json:"color"`
+	LocalMemberState string         `json:"local_member_state"`
+	Members          []memberStatus `json:"members"`
+	Anomalies        []string       `json:"anomalies"`
+}
+
 const (
 	stateOnline      = "ONLINE"
 	stateRecovering  = "RECOVERING"
@@ -30,64 +54,345 @@ const (
 	stateUnreachable = "UNREACHABLE"
 )
 
+const (
+	colorGreen  = "GREEN"
+	colorYellow = "YELLOW"
+	colorRed    = "RED"
+)
+
+const (
+	roleAny       = "any"
+	rolePrimary   = "primary"
+	roleSecondary = "secondary"
+)
+
+// colorForCheckerState maps a checker state to its JSON color name.
+func colorForCheckerState(checkSt checkers.Status) string {
+	switch checkSt {
+	case checkers.OK:
+		return colorGreen
+	case checkers.WARNING:
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
+
+// anyMemberRecovering reports whether any group member, local or remote, is
+// currently RECOVERING.
+func anyMemberRecovering(db *sql.DB) (bool, error) {
+	row := db.QueryRow(`
+SELECT EXISTS (
+	SELECT 1
+	FROM   performance_schema.replication_group_members
+	WHERE  MEMBER_STATE = 'RECOVERING'
+);
+`)
+
+	var recovering bool
+	if err := row.Scan(&recovering); err != nil {
+		return false, fmt.Errorf("couldn't execute query")
+	}
+	return recovering, nil
+}
+
 // getLocalMemberState returns the state of the local host.
-func getLocalMemberState(db *mysql.Conn, localHostname string, localPort string) (string, error) {
-	stmt, err := (*db).Prepare(`
-SELECT MEMBER_STATE 
-FROM   performance_schema.replication_group_members 
+func getLocalMemberState(db *sql.DB, localHostname string, localPort string) (string, error) {
+	row := db.QueryRow(`
+SELECT MEMBER_STATE
+FROM   performance_schema.replication_group_members
 WHERE  MEMBER_HOST = ? AND MEMBER_PORT = ?;
-`)
-	if err != nil {
+`, localHostname, localPort)
+
+	var localMemberState string
+	if err := row.Scan(&localMemberState); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("%s:%s is not a group member", localHostname, localPort)
+		}
 		return "", fmt.Errorf("couldn't execute query")
 	}
+	return localMemberState, nil
+}
 
-	rows, res, err := stmt.Exec(localHostname, localPort)
-	if err != nil {
+// getLocalMemberRole returns the local member's MEMBER_ROLE (PRIMARY or
+// SECONDARY, 8.0+ only).
+func getLocalMemberRole(db *sql.DB, localHostname string, localPort string) (string, error) {
+	row := db.QueryRow(`
+SELECT MEMBER_ROLE
+FROM   performance_schema.replication_group_members
+WHERE  MEMBER_HOST = ? AND MEMBER_PORT = ?;
+`, localHostname, localPort)
+
+	var memberRole string
+	if err := row.Scan(&memberRole); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("%s:%s is not a group member", localHostname, localPort)
+		}
 		return "", fmt.Errorf("couldn't execute query")
 	}
+	return memberRole, nil
+}
+
+// getGlobalVariable returns the value of a single global system variable.
+func getGlobalVariable(db *sql.DB, name string) (string, error) {
+	row := db.QueryRow(`
+SELECT VARIABLE_VALUE
+FROM   performance_schema.global_variables
+WHERE  VARIABLE_NAME = ?;
+`, name)
 
-	if len(rows) == 0 {
-		return "", fmt.Errorf("%s:%s is not a group member", localHostname, localPort)
+	var value string
+	if err := row.Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("%s is not a known global variable", name)
+		}
+		return "", fmt.Errorf("couldn't execute query")
 	}
+	return value, nil
+}
 
-	idxMemberState := res.Map("MEMBER_STATE")
-	localMemberState := rows[0].Str(idxMemberState)
-	return localMemberState, nil
+// evaluateMemberRole compares the local member's role against --expect-role.
+func evaluateMemberRole(expectRole string, role string) (checkers.Status, string) {
+	if expectRole == "" {
+		expectRole = roleAny
+	}
+	if strings.EqualFold(expectRole, roleAny) || strings.EqualFold(role, expectRole) {
+		return checkers.OK, ""
+	}
+	return checkers.WARNING, fmt.Sprintf("expected role %s but member role is %s", strings.ToUpper(expectRole), role)
+}
+
+// evaluateReadOnly checks that a SECONDARY member has super_read_only=ON.
+func evaluateReadOnly(role string, superReadOnly string) (checkers.Status, string) {
+	if !strings.EqualFold(role, roleSecondary) || strings.EqualFold(superReadOnly, "ON") {
+		return checkers.OK, ""
+	}
+	return checkers.WARNING, "SECONDARY member does not have super_read_only=ON"
+}
+
+// checkRoleAndReadOnly reports a role mismatch against --expect-role and, for
+// SECONDARY members, a super_read_only inconsistency when --check-read-only
+// is set. This is a distinct axis from MEMBER_STATE, so it produces its own
+// WARNING reasons in the combined message.
+func checkRoleAndReadOnly(db *sql.DB, opts groupReplicationOpts) (checkers.Status, string, error) {
+	expectRole := opts.ExpectRole
+	if expectRole == "" {
+		expectRole = roleAny
+	}
+	if strings.EqualFold(expectRole, roleAny) && !opts.CheckReadOnly {
+		return checkers.OK, "", nil
+	}
+
+	role, err := getLocalMemberRole(db, opts.LocalHostname, opts.LocalPort)
+	if err != nil {
+		return checkers.OK, "", err
+	}
+
+	checkSt := checkers.OK
+	var reasons []string
+
+	if roleSt, reason := evaluateMemberRole(expectRole, role); roleSt != checkers.OK {
+		checkSt = roleSt
+		reasons = append(reasons, reason)
+	}
+
+	if opts.CheckReadOnly && strings.EqualFold(role, roleSecondary) {
+		superReadOnly, err := getGlobalVariable(db, "super_read_only")
+		if err != nil {
+			return checkers.OK, "", err
+		}
+		if roSt, reason := evaluateReadOnly(role, superReadOnly); roSt != checkers.OK {
+			if checkSt == checkers.OK {
+				checkSt = roSt
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+
+	return checkSt, strings.Join(reasons, ", "), nil
 }
 
 // getGroupMembers returns a list of group members that have detected an anomaly.
-func getGroupMembers(db *mysql.Conn, localHostname string, localPort string) ([]groupMember, error) {
+func getGroupMembers(db *sql.DB, localHostname string, localPort string) ([]groupMember, error) {
 	var groupMembers []groupMember
 
-	stmt, err := (*db).Prepare(`
-SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_STATE 
-FROM   performance_schema.replication_group_members 
-WHERE  MEMBER_STATE NOT IN ( 'ONLINE', 'RECOVERING' ) 
+	rows, err := db.Query(`
+SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_STATE
+FROM   performance_schema.replication_group_members
+WHERE  MEMBER_STATE NOT IN ( 'ONLINE', 'RECOVERING' )
        AND NOT ( MEMBER_HOST = ? AND MEMBER_PORT = ? )
 ORDER  BY MEMBER_HOST;
-`)
+`, localHostname, localPort)
 	if err != nil {
 		return groupMembers, fmt.Errorf("couldn't execute query")
 	}
+	defer rows.Close()
 
-	rows, res, err := stmt.Exec(localHostname, localPort)
-	if err != nil {
-		return groupMembers, fmt.Errorf("couldn't execute query")
-	}
-
-	idxMemberHost := res.Map("MEMBER_HOST")
-	idxMemberPort := res.Map("MEMBER_PORT")
-	idxMemberState := res.Map("MEMBER_STATE")
-	for _, row := range rows {
-		memberHost := fmt.Sprintf("%s:%s", row.Str(idxMemberHost), row.Str(idxMemberPort))
+	for rows.Next() {
+		var memberHost, memberPort, memberState string
+		if err := rows.Scan(&memberHost, &memberPort, &memberState); err != nil {
+			return groupMembers, fmt.Errorf("couldn't execute query")
+		}
 		groupMembers = append(
 			groupMembers,
 			groupMember{
-				Host:  memberHost,
-				State: row.Str(idxMemberState),
+				Host:  fmt.Sprintf("%s:%s", memberHost, memberPort),
+				State: memberState,
 			})
 	}
-	return groupMembers, nil
+	return groupMembers, rows.Err()
+}
+
+type memberStats struct {
+	QueueDepth int64
+	LagTxns    int64
+}
+
+// getLocalMemberStats returns the applier queue depth and certification lag
+// of the local member from performance_schema.replication_group_member_stats.
+func getLocalMemberStats(db *sql.DB, localHostname string, localPort string) (memberStats, error) {
+	var stats memberStats
+
+	row := db.QueryRow(`
+SELECT s.COUNT_TRANSACTIONS_IN_QUEUE, s.COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE,
+       s.COUNT_TRANSACTIONS_CHECKED, s.COUNT_TRANSACTIONS_REMOTE_APPLIED
+FROM   performance_schema.replication_group_member_stats s
+       JOIN performance_schema.replication_group_members m ON m.MEMBER_ID = s.MEMBER_ID
+WHERE  m.MEMBER_HOST = ? AND m.MEMBER_PORT = ?;
+`, localHostname, localPort)
+
+	var queue, applierQueue, checked, applied int64
+	if err := row.Scan(&queue, &applierQueue, &checked, &applied); err != nil {
+		if err == sql.ErrNoRows {
+			return stats, fmt.Errorf("%s:%s is not a group member", localHostname, localPort)
+		}
+		return stats, fmt.Errorf("couldn't execute query")
+	}
+	stats.QueueDepth = queue + applierQueue
+	stats.LagTxns = checked - applied
+	return stats, nil
+}
+
+// getOnlineMemberCount returns the number of group members currently ONLINE.
+func getOnlineMemberCount(db *sql.DB) (int64, error) {
+	row := db.QueryRow(`
+SELECT COUNT(*)
+FROM   performance_schema.replication_group_members
+WHERE  MEMBER_STATE = 'ONLINE';
+`)
+
+	var online int64
+	if err := row.Scan(&online); err != nil {
+		return 0, fmt.Errorf("couldn't execute query")
+	}
+	return online, nil
+}
+
+// getPrimaryMemberHost returns the host:port of the member recorded as the
+// group's PRIMARY in single-primary mode, or "" if none can be identified.
+func getPrimaryMemberHost(db *sql.DB) (string, error) {
+	row := db.QueryRow(`
+SELECT m.MEMBER_HOST, m.MEMBER_PORT
+FROM   performance_schema.global_status g
+       JOIN performance_schema.replication_group_members m ON m.MEMBER_ID = g.VARIABLE_VALUE
+WHERE  g.VARIABLE_NAME = 'group_replication_primary_member';
+`)
+
+	var memberHost, memberPort string
+	if err := row.Scan(&memberHost, &memberPort); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("couldn't execute query")
+	}
+	return fmt.Sprintf("%s:%s", memberHost, memberPort), nil
+}
+
+// checkQuorum compares the ONLINE member count against the expected group size.
+func checkQuorum(expectedMembers int64, online int64) (checkers.Status, string) {
+	if expectedMembers <= 0 {
+		return checkers.OK, ""
+	}
+
+	quorum := expectedMembers/2 + 1
+	switch {
+	case online < quorum:
+		return checkers.CRITICAL, fmt.Sprintf("quorum lost: %d/%d member(s) online (need >= %d)", online, expectedMembers, quorum)
+	case online < expectedMembers:
+		return checkers.WARNING, fmt.Sprintf("%d instance(s) missing from cluster (expected %d, online %d)", expectedMembers-online, expectedMembers, online)
+	default:
+		return checkers.OK, ""
+	}
+}
+
+// getAllGroupMembers returns every group member, regardless of state, for
+// building the structured JSON status document.
+func getAllGroupMembers(db *sql.DB) ([]memberStatus, error) {
+	var members []memberStatus
+
+	rows, err := db.Query(`
+SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_ROLE, MEMBER_STATE
+FROM   performance_schema.replication_group_members
+ORDER  BY MEMBER_HOST;
+`)
+	if err != nil {
+		return members, fmt.Errorf("couldn't execute query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member memberStatus
+		if err := rows.Scan(&member.Host, &member.Port, &member.Role, &member.State); err != nil {
+			return members, fmt.Errorf("couldn't execute query")
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// checkMemberStats evaluates the local member's queue depth and certification
+// lag against the configured thresholds, so a node that is ONLINE but falling
+// behind is still reported WARNING/CRITICAL.
+func checkMemberStats(opts groupReplicationOpts, stats memberStats) (checkers.Status, string) {
+	checkSt := checkers.OK
+	var reasons []string
+
+	switch {
+	case opts.CritQueue > 0 && stats.QueueDepth >= opts.CritQueue:
+		checkSt = checkers.CRITICAL
+		reasons = append(reasons, fmt.Sprintf("applier queue backlog is %d (>= %d)", stats.QueueDepth, opts.CritQueue))
+	case opts.WarnQueue > 0 && stats.QueueDepth >= opts.WarnQueue:
+		checkSt = checkers.WARNING
+		reasons = append(reasons, fmt.Sprintf("applier queue backlog is %d (>= %d)", stats.QueueDepth, opts.WarnQueue))
+	}
+
+	switch {
+	case opts.CritLagTxns > 0 && stats.LagTxns >= opts.CritLagTxns:
+		checkSt = checkers.CRITICAL
+		reasons = append(reasons, fmt.Sprintf("certification lag is %d transactions (>= %d)", stats.LagTxns, opts.CritLagTxns))
+	case opts.WarnLagTxns > 0 && stats.LagTxns >= opts.WarnLagTxns:
+		if checkSt == checkers.OK {
+			checkSt = checkers.WARNING
+		}
+		reasons = append(reasons, fmt.Sprintf("certification lag is %d transactions (>= %d)", stats.LagTxns, opts.WarnLagTxns))
+	}
+
+	return checkSt, strings.Join(reasons, ", ")
+}
+
+// buildClusterStatus assembles the structured document emitted by
+// --output=json from the already-computed Nagios state and member list.
+func buildClusterStatus(checkSt checkers.Status, localMemberState string, members []memberStatus, groupMembers []groupMember) clusterStatus {
+	anomalies := make([]string, 0, len(groupMembers))
+	for _, member := range groupMembers {
+		anomalies = append(anomalies, fmt.Sprintf("%s %s", member.Host, member.State))
+	}
+	return clusterStatus{
+		Color:            colorForCheckerState(checkSt),
+		LocalMemberState: localMemberState,
+		Members:          members,
+		Anomalies:        anomalies,
+	}
 }
 
 func checkGroupReplication(args []string) *checkers.Checker {
@@ -98,14 +403,16 @@ func checkGroupReplication(args []string) *checkers.Checker {
 	if err != nil {
 		os.Exit(1)
 	}
-	db := newMySQL(opts.mysqlSetting)
-	err = db.Connect()
+	db, err := newMySQL(opts.mysqlSetting)
 	if err != nil {
-		return checkers.Unknown("couldn't connect DB")
+		return checkers.Unknown(err.Error())
 	}
 	defer db.Close()
+	if err := db.Ping(); err != nil {
+		return checkers.Unknown("couldn't connect DB")
+	}
 
-	localMemberState, err := getLocalMemberState(&db, opts.LocalHostname, opts.LocalPort)
+	localMemberState, err := getLocalMemberState(db, opts.LocalHostname, opts.LocalPort)
 	if err != nil {
 		return checkers.Unknown(err.Error())
 	}
@@ -119,27 +426,106 @@ func checkGroupReplication(args []string) *checkers.Checker {
 		checkSt = checkers.CRITICAL
 	}
 
-	if !opts.GroupMember {
-		return checkers.NewChecker(checkSt, msg)
+	recovering, err := anyMemberRecovering(db)
+	if err != nil {
+		return checkers.Unknown(err.Error())
 	}
+	if recovering && checkSt == checkers.OK {
+		checkSt = checkers.WARNING
+		msg = fmt.Sprintf("%s. A group member is RECOVERING", msg)
+	}
+
+	if opts.WarnQueue > 0 || opts.CritQueue > 0 || opts.WarnLagTxns > 0 || opts.CritLagTxns > 0 {
+		stats, err := getLocalMemberStats(db, opts.LocalHostname, opts.LocalPort)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+
+		statSt, reason := checkMemberStats(opts, stats)
+		if statSt > checkSt {
+			checkSt = statSt
+		}
+		if reason != "" {
+			msg = fmt.Sprintf("%s. %s", msg, reason)
+		}
+	}
+
+	if opts.ExpectedMembers > 0 {
+		online, err := getOnlineMemberCount(db)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
 
-	groupMembers, err := getGroupMembers(&db, opts.LocalHostname, opts.LocalPort)
+		quorumSt, reason := checkQuorum(opts.ExpectedMembers, online)
+		if quorumSt > checkSt {
+			checkSt = quorumSt
+		}
+		if reason != "" {
+			msg = fmt.Sprintf("%s. %s", msg, reason)
+		}
+	}
+
+	if opts.RequirePrimary {
+		singlePrimaryMode, err := getGlobalVariable(db, "group_replication_single_primary_mode")
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+		if strings.EqualFold(singlePrimaryMode, "ON") {
+			primaryHost, err := getPrimaryMemberHost(db)
+			if err != nil {
+				return checkers.Unknown(err.Error())
+			}
+			if primaryHost == "" {
+				checkSt = checkers.CRITICAL
+				msg = fmt.Sprintf("%s. no PRIMARY member could be identified", msg)
+			}
+		}
+	}
+
+	roleSt, reason, err := checkRoleAndReadOnly(db, opts)
 	if err != nil {
 		return checkers.Unknown(err.Error())
 	}
+	if roleSt > checkSt {
+		checkSt = roleSt
+	}
+	if reason != "" {
+		msg = fmt.Sprintf("%s. %s", msg, reason)
+	}
 
-	if len(groupMembers) > 0 {
-		if checkSt == checkers.OK {
-			checkSt = checkers.WARNING
+	var groupMembers []groupMember
+	if opts.GroupMember || opts.Output == "json" {
+		groupMembers, err = getGroupMembers(db, opts.LocalHostname, opts.LocalPort)
+		if err != nil {
+			return checkers.Unknown(err.Error())
+		}
+
+		if opts.GroupMember && len(groupMembers) > 0 {
+			if checkSt == checkers.OK {
+				checkSt = checkers.WARNING
+			}
+			var groupMembersList []string
+			for _, member := range groupMembers {
+				groupMembersList = append(
+					groupMembersList,
+					fmt.Sprintf("%s %s", member.Host, member.State))
+			}
+			groupMembersState := strings.Join(groupMembersList, ", ")
+			msg = fmt.Sprintf("%s. Anomalies were detected in other group members: %s", msg, groupMembersState)
+		}
+	}
+
+	if opts.Output == "json" {
+		members, err := getAllGroupMembers(db)
+		if err != nil {
+			return checkers.Unknown(err.Error())
 		}
-		var groupMembersList []string
-		for _, member := range groupMembers {
-			groupMembersList = append(
-				groupMembersList,
-				fmt.Sprintf("%s %s", member.Host, member.State))
+		status := buildClusterStatus(checkSt, localMemberState, members, groupMembers)
+		body, err := json.Marshal(status)
+		if err != nil {
+			return checkers.Unknown(err.Error())
 		}
-		groupMembersState := strings.Join(groupMembersList, ", ")
-		msg = fmt.Sprintf("%s. Anomalies were detected in other group members: %s", localMemberState, groupMembersState)
+		msg = string(body)
 	}
 
 	return checkers.NewChecker(checkSt, msg)
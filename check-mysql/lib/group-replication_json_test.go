@@ -0,0 +1,89 @@
+package checkmysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mackerelio/checkers"
+)
+
+func TestColorForCheckerState(t *testing.T) {
+	tests := []struct {
+		name      string
+		checkSt   checkers.Status
+		wantColor string
+	}{
+		{"ok is green", checkers.OK, colorGreen},
+		{"warning is yellow", checkers.WARNING, colorYellow},
+		{"critical is red", checkers.CRITICAL, colorRed},
+		{"unknown is red", checkers.UNKNOWN, colorRed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			color := colorForCheckerState(tt.checkSt)
+			if color != tt.wantColor {
+				t.Errorf("colorForCheckerState(%v) = %s, want %s", tt.checkSt, color, tt.wantColor)
+			}
+		})
+	}
+}
+
+func TestBuildClusterStatus(t *testing.T) {
+	tests := []struct {
+		name             string
+		checkSt          checkers.Status
+		localMemberState string
+		members          []memberStatus
+		groupMembers     []groupMember
+		want             clusterStatus
+	}{
+		{
+			name:             "healthy cluster has no anomalies",
+			checkSt:          checkers.OK,
+			localMemberState: stateOnline,
+			members: []memberStatus{
+				{Host: "db1", Port: "3306", Role: "PRIMARY", State: stateOnline},
+				{Host: "db2", Port: "3306", Role: "SECONDARY", State: stateOnline},
+			},
+			groupMembers: nil,
+			want: clusterStatus{
+				Color:            colorGreen,
+				LocalMemberState: stateOnline,
+				Members: []memberStatus{
+					{Host: "db1", Port: "3306", Role: "PRIMARY", State: stateOnline},
+					{Host: "db2", Port: "3306", Role: "SECONDARY", State: stateOnline},
+				},
+				Anomalies: []string{},
+			},
+		},
+		{
+			name:             "offline member becomes an anomaly string",
+			checkSt:          checkers.CRITICAL,
+			localMemberState: stateOnline,
+			members: []memberStatus{
+				{Host: "db1", Port: "3306", Role: "PRIMARY", State: stateOnline},
+				{Host: "db2", Port: "3306", Role: "SECONDARY", State: stateOffline},
+			},
+			groupMembers: []groupMember{
+				{Host: "db2", State: stateOffline},
+			},
+			want: clusterStatus{
+				Color:            colorRed,
+				LocalMemberState: stateOnline,
+				Members: []memberStatus{
+					{Host: "db1", Port: "3306", Role: "PRIMARY", State: stateOnline},
+					{Host: "db2", Port: "3306", Role: "SECONDARY", State: stateOffline},
+				},
+				Anomalies: []string{"db2 OFFLINE"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildClusterStatus(tt.checkSt, tt.localMemberState, tt.members, tt.groupMembers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildClusterStatus(...) = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package checkmysql
+
+import (
+	"testing"
+
+	"github.com/mackerelio/checkers"
+)
+
+func TestCheckQuorum(t *testing.T) {
+	tests := []struct {
+		name            string
+		expectedMembers int64
+		online          int64
+		wantSt          checkers.Status
+	}{
+		{"disabled when expected-members is unset", 0, 0, checkers.OK},
+		{"disabled when expected-members is negative", -1, 5, checkers.OK},
+		{"all members online", 3, 3, checkers.OK},
+		{"one missing but quorum holds (3/2+1=2)", 3, 2, checkers.WARNING},
+		{"quorum lost (3/2+1=2)", 3, 1, checkers.CRITICAL},
+		{"five member cluster, quorum holds but members missing at 3", 5, 3, checkers.WARNING},
+		{"five member cluster, one missing", 5, 4, checkers.WARNING},
+		{"five member cluster, quorum lost at 2", 5, 2, checkers.CRITICAL},
+		{"single member cluster never loses quorum alone", 1, 1, checkers.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, reason := checkQuorum(tt.expectedMembers, tt.online)
+			if st != tt.wantSt {
+				t.Errorf("checkQuorum(%d, %d) = %v, want %v", tt.expectedMembers, tt.online, st, tt.wantSt)
+			}
+			if st != checkers.OK && reason == "" {
+				t.Errorf("checkQuorum(%d, %d) returned no reason for a non-OK status", tt.expectedMembers, tt.online)
+			}
+		})
+	}
+}
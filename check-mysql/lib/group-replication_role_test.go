@@ -0,0 +1,60 @@
+package checkmysql
+
+import (
+	"testing"
+
+	"github.com/mackerelio/checkers"
+)
+
+func TestEvaluateMemberRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		expectRole string
+		role       string
+		wantSt     checkers.Status
+	}{
+		{"any accepts primary", "any", "PRIMARY", checkers.OK},
+		{"any accepts secondary", "any", "SECONDARY", checkers.OK},
+		{"empty behaves like any", "", "SECONDARY", checkers.OK},
+		{"matching primary", "primary", "PRIMARY", checkers.OK},
+		{"matching secondary is case-insensitive", "SECONDARY", "secondary", checkers.OK},
+		{"former primary became secondary", "primary", "SECONDARY", checkers.WARNING},
+		{"former secondary became primary", "secondary", "PRIMARY", checkers.WARNING},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, reason := evaluateMemberRole(tt.expectRole, tt.role)
+			if st != tt.wantSt {
+				t.Errorf("evaluateMemberRole(%q, %q) = %v, want %v", tt.expectRole, tt.role, st, tt.wantSt)
+			}
+			if st != checkers.OK && reason == "" {
+				t.Errorf("evaluateMemberRole(%q, %q) returned no reason for a non-OK status", tt.expectRole, tt.role)
+			}
+		})
+	}
+}
+
+func TestEvaluateReadOnly(t *testing.T) {
+	tests := []struct {
+		name          string
+		role          string
+		superReadOnly string
+		wantSt        checkers.Status
+	}{
+		{"primary is not checked", "PRIMARY", "OFF", checkers.OK},
+		{"secondary with super_read_only ON", "SECONDARY", "ON", checkers.OK},
+		{"secondary with super_read_only OFF", "SECONDARY", "OFF", checkers.WARNING},
+		{"secondary is case-insensitive", "secondary", "on", checkers.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, reason := evaluateReadOnly(tt.role, tt.superReadOnly)
+			if st != tt.wantSt {
+				t.Errorf("evaluateReadOnly(%q, %q) = %v, want %v", tt.role, tt.superReadOnly, st, tt.wantSt)
+			}
+			if st != checkers.OK && reason == "" {
+				t.Errorf("evaluateReadOnly(%q, %q) returned no reason for a non-OK status", tt.role, tt.superReadOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package checkmysql
+
+import (
+	"testing"
+
+	"github.com/mackerelio/checkers"
+)
+
+func TestCheckMemberStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   groupReplicationOpts
+		stats  memberStats
+		wantSt checkers.Status
+	}{
+		{
+			name:   "thresholds unset",
+			opts:   groupReplicationOpts{},
+			stats:  memberStats{QueueDepth: 1000, LagTxns: 1000},
+			wantSt: checkers.OK,
+		},
+		{
+			name:   "queue below warning",
+			opts:   groupReplicationOpts{WarnQueue: 100, CritQueue: 200},
+			stats:  memberStats{QueueDepth: 50},
+			wantSt: checkers.OK,
+		},
+		{
+			name:   "queue crosses warning",
+			opts:   groupReplicationOpts{WarnQueue: 100, CritQueue: 200},
+			stats:  memberStats{QueueDepth: 150},
+			wantSt: checkers.WARNING,
+		},
+		{
+			name:   "queue crosses critical takes precedence over warning",
+			opts:   groupReplicationOpts{WarnQueue: 100, CritQueue: 200},
+			stats:  memberStats{QueueDepth: 250},
+			wantSt: checkers.CRITICAL,
+		},
+		{
+			name:   "lag crosses warning",
+			opts:   groupReplicationOpts{WarnLagTxns: 1000, CritLagTxns: 5000},
+			stats:  memberStats{LagTxns: 1500},
+			wantSt: checkers.WARNING,
+		},
+		{
+			name:   "lag crosses critical",
+			opts:   groupReplicationOpts{WarnLagTxns: 1000, CritLagTxns: 5000},
+			stats:  memberStats{LagTxns: 6000},
+			wantSt: checkers.CRITICAL,
+		},
+		{
+			name:   "queue critical is not downgraded by an OK lag",
+			opts:   groupReplicationOpts{CritQueue: 200, WarnLagTxns: 1000},
+			stats:  memberStats{QueueDepth: 300, LagTxns: 10},
+			wantSt: checkers.CRITICAL,
+		},
+		{
+			name:   "lag warning does not downgrade an existing queue critical",
+			opts:   groupReplicationOpts{CritQueue: 200, WarnLagTxns: 1000},
+			stats:  memberStats{QueueDepth: 300, LagTxns: 1500},
+			wantSt: checkers.CRITICAL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, reason := checkMemberStats(tt.opts, tt.stats)
+			if st != tt.wantSt {
+				t.Errorf("checkMemberStats(%+v, %+v) = %v, want %v", tt.opts, tt.stats, st, tt.wantSt)
+			}
+			if st != checkers.OK && reason == "" {
+				t.Errorf("checkMemberStats(%+v, %+v) returned no reason for a non-OK status", tt.opts, tt.stats)
+			}
+		})
+	}
+}
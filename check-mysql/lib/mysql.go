@@ -0,0 +1,112 @@
+package checkmysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlSetting is embedded by every checkmysql subcommand's options struct to
+// provide a common set of connection flags.
+type mysqlSetting struct {
+	Host          string `short:"H" long:"hostname" default:"localhost" description:"Hostname"`
+	Port          string `short:"p" long:"port" default:"3306" description:"Port"`
+	Socket        string `long:"socket" description:"Path to a Unix socket, e.g. /var/run/mysqld/mysqld.sock. Takes precedence over --hostname/--port."`
+	User          string `short:"u" long:"user" description:"Username"`
+	Password      string `long:"password" description:"Password"`
+	Database      string `short:"d" long:"database" description:"Database name"`
+	TLS           bool   `long:"tls" description:"Use TLS for the connection"`
+	TLSCA         string `long:"tls-ca" description:"Path to a PEM-encoded CA certificate bundle used to verify the server certificate"`
+	TLSCert       string `long:"tls-cert" description:"Path to a PEM-encoded client certificate, for mutual TLS"`
+	TLSKey        string `long:"tls-key" description:"Path to the PEM-encoded private key for --tls-cert"`
+	TLSSkipVerify bool   `long:"tls-skip-verify" description:"Skip verification of the server certificate (insecure; for testing only)"`
+	AuthPlugin    string `long:"auth-plugin" description:"Authentication plugin hint, e.g. caching_sha2_password or mysql_clear_password"`
+}
+
+const tlsConfigName = "checkmysql"
+
+// dsnConfig builds a *mysql.Config for the given connection settings, without
+// touching the network or the TLS config registry.
+func dsnConfig(s mysqlSetting) *mysql.Config {
+	cfg := mysql.NewConfig()
+	cfg.User = s.User
+	cfg.Passwd = s.Password
+	cfg.DBName = s.Database
+
+	if s.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = s.Socket
+	} else {
+		cfg.Net = "tcp"
+		cfg.Addr = fmt.Sprintf("%s:%s", s.Host, s.Port)
+	}
+
+	switch s.AuthPlugin {
+	case "":
+	case "mysql_clear_password":
+		cfg.AllowCleartextPasswords = true
+	case "mysql_old_password":
+		cfg.AllowOldPasswords = true
+	}
+
+	return cfg
+}
+
+// newMySQL builds a *sql.DB for the given connection settings. It is shared
+// by every checkmysql subcommand so that uptime, connection,
+// group-replication, etc. all gain TLS/socket/auth-plugin support the same
+// way.
+func newMySQL(s mysqlSetting) (*sql.DB, error) {
+	cfg := dsnConfig(s)
+
+	if s.TLS {
+		tlsConfig, err := newTLSConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := mysql.RegisterTLSConfig(tlsConfigName, tlsConfig); err != nil {
+			return nil, fmt.Errorf("couldn't register TLS config: %s", err)
+		}
+		cfg.TLSConfig = tlsConfigName
+	}
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open connection to DB")
+	}
+	return db, nil
+}
+
+// newTLSConfig builds a *tls.Config from the --tls-* options: an optional CA
+// bundle to verify the server certificate against, an optional client
+// certificate for mutual TLS, and an escape hatch to skip verification
+// entirely for testing.
+func newTLSConfig(s mysqlSetting) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: s.TLSSkipVerify}
+
+	if s.TLSCA != "" {
+		pem, err := os.ReadFile(s.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read --tls-ca: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("couldn't parse --tls-ca")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if s.TLSCert != "" || s.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load --tls-cert/--tls-key: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,178 @@
+package checkmysql
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDsnConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		setting        mysqlSetting
+		wantNet        string
+		wantAddr       string
+		wantCleartext  bool
+		wantOldPasswds bool
+	}{
+		{
+			name:     "tcp host and port",
+			setting:  mysqlSetting{Host: "db.example.com", Port: "3306", User: "nagios", Password: "secret", Database: "mysql"},
+			wantNet:  "tcp",
+			wantAddr: "db.example.com:3306",
+		},
+		{
+			name:     "unix socket takes precedence over host/port",
+			setting:  mysqlSetting{Host: "db.example.com", Port: "3306", Socket: "/var/run/mysqld/mysqld.sock"},
+			wantNet:  "unix",
+			wantAddr: "/var/run/mysqld/mysqld.sock",
+		},
+		{
+			name:          "mysql_clear_password sets AllowCleartextPasswords",
+			setting:       mysqlSetting{Host: "localhost", Port: "3306", AuthPlugin: "mysql_clear_password"},
+			wantNet:       "tcp",
+			wantAddr:      "localhost:3306",
+			wantCleartext: true,
+		},
+		{
+			name:           "mysql_old_password sets AllowOldPasswords",
+			setting:        mysqlSetting{Host: "localhost", Port: "3306", AuthPlugin: "mysql_old_password"},
+			wantNet:        "tcp",
+			wantAddr:       "localhost:3306",
+			wantOldPasswds: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := dsnConfig(tt.setting)
+			if cfg.Net != tt.wantNet {
+				t.Errorf("Net = %q, want %q", cfg.Net, tt.wantNet)
+			}
+			if cfg.Addr != tt.wantAddr {
+				t.Errorf("Addr = %q, want %q", cfg.Addr, tt.wantAddr)
+			}
+			if cfg.User != tt.setting.User {
+				t.Errorf("User = %q, want %q", cfg.User, tt.setting.User)
+			}
+			if cfg.Passwd != tt.setting.Password {
+				t.Errorf("Passwd = %q, want %q", cfg.Passwd, tt.setting.Password)
+			}
+			if cfg.DBName != tt.setting.Database {
+				t.Errorf("DBName = %q, want %q", cfg.DBName, tt.setting.Database)
+			}
+			if cfg.AllowCleartextPasswords != tt.wantCleartext {
+				t.Errorf("AllowCleartextPasswords = %v, want %v", cfg.AllowCleartextPasswords, tt.wantCleartext)
+			}
+			if cfg.AllowOldPasswords != tt.wantOldPasswds {
+				t.Errorf("AllowOldPasswords = %v, want %v", cfg.AllowOldPasswords, tt.wantOldPasswds)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certPath, keyPath := writeSelfSignedCert(t)
+
+	t.Run("skip verify with no CA or client cert", func(t *testing.T) {
+		cfg, err := newTLSConfig(mysqlSetting{TLSSkipVerify: true})
+		if err != nil {
+			t.Fatalf("newTLSConfig() error = %v", err)
+		}
+		if !cfg.InsecureSkipVerify {
+			t.Error("InsecureSkipVerify = false, want true")
+		}
+		if cfg.RootCAs != nil {
+			t.Error("RootCAs should be nil when --tls-ca is not set")
+		}
+		if len(cfg.Certificates) != 0 {
+			t.Error("Certificates should be empty when --tls-cert/--tls-key are not set")
+		}
+	})
+
+	t.Run("loads CA bundle", func(t *testing.T) {
+		cfg, err := newTLSConfig(mysqlSetting{TLSCA: certPath})
+		if err != nil {
+			t.Fatalf("newTLSConfig() error = %v", err)
+		}
+		if cfg.RootCAs == nil {
+			t.Error("RootCAs was not populated from --tls-ca")
+		}
+	})
+
+	t.Run("invalid CA path errors", func(t *testing.T) {
+		if _, err := newTLSConfig(mysqlSetting{TLSCA: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+			t.Error("expected an error for a missing --tls-ca file")
+		}
+	})
+
+	t.Run("loads client certificate", func(t *testing.T) {
+		cfg, err := newTLSConfig(mysqlSetting{TLSCert: certPath, TLSKey: keyPath})
+		if err != nil {
+			t.Fatalf("newTLSConfig() error = %v", err)
+		}
+		if len(cfg.Certificates) != 1 {
+			t.Errorf("Certificates = %d entries, want 1", len(cfg.Certificates))
+		}
+	})
+
+	t.Run("invalid client certificate errors", func(t *testing.T) {
+		if _, err := newTLSConfig(mysqlSetting{TLSCert: certPath, TLSKey: filepath.Join(t.TempDir(), "missing.key")}); err == nil {
+			t.Error("expected an error for a missing --tls-key file")
+		}
+	})
+}
+
+// writeSelfSignedCert generates a throwaway self-signed certificate/key pair
+// for exercising the --tls-ca/--tls-cert/--tls-key loading paths.
+func writeSelfSignedCert(t *testing.T) (certPath string, keyPath string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "checkmysql-test"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).AddDate(100, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+
+	certOut, err := os.Create(certPath)
+	if err != nil {
+		t.Fatalf("couldn't create %s: %v", certPath, err)
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		t.Fatalf("couldn't write %s: %v", certPath, err)
+	}
+
+	keyOut, err := os.Create(keyPath)
+	if err != nil {
+		t.Fatalf("couldn't create %s: %v", keyPath, err)
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}); err != nil {
+		t.Fatalf("couldn't write %s: %v", keyPath, err)
+	}
+
+	return certPath, keyPath
+}